@@ -0,0 +1,112 @@
+package cache
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+import (
+	"math"
+	"time"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// Vitals holds the cache health metrics gathered from an Astats poll, used
+// both for display and for deciding whether two polls are equivalent.
+type Vitals struct {
+	LoadAvg   float64
+	QueryTime int64
+	KbpsOut   float64
+}
+
+// AstatsData holds the subset of the raw ATS astats payload health checks
+// care about, keyed by stat name.
+type AstatsData struct {
+	ATSStats map[string]float64
+}
+
+// Result is the result of a single health or stat poll of a cache.
+type Result struct {
+	ID           tc.CacheName
+	Error        error
+	Time         time.Time
+	Vitals       Vitals
+	Available    bool
+	StatusCode   int
+	Astats       AstatsData
+	CertExpiry   time.Time
+	PollID       uint64
+	PollFinished chan uint64
+
+	// Span, FirstTime and LastTime make a Result do double duty as a
+	// run-length-compressed history entry: a Result with Span > 1 represents
+	// Span consecutive polls - from FirstTime to LastTime - that were all
+	// equivalent to the stored one, per ResultsEquivalent. A freshly polled
+	// Result that hasn't been merged into history yet has Span 0.
+	//
+	// This doubles as the wire-compatible migration path for peer-exchanged
+	// history: a Result from a monitor that predates span compression simply
+	// never sets Span, so it arrives as the same Span == 0 a fresh,
+	// not-yet-merged poll would have. Span-aware consumers - see
+	// threadsafe.ResultHistory.Expanded - already treat Span < 1 as a single
+	// poll, so a mixed cluster of old and new monitors interoperates without
+	// either side needing to know the other's version.
+	Span      int
+	FirstTime time.Time
+	LastTime  time.Time
+}
+
+// resultEpsilon is used when the caller-configured epsilon is unset (zero or
+// negative), so two noise-free identical polls still collapse into a span
+// even if the vitals aren't bit-for-bit equal.
+const resultEpsilon = 0.0001
+
+// ResultsEquivalent reports whether b is a plausible continuation of a for the
+// purposes of run-length history compression: same error state, same
+// availability, same status code, and KbpsOut and LoadAvg within epsilon of
+// each other. It does not compare Vitals.QueryTime, nor
+// Span/FirstTime/LastTime, since those describe b's position in a history
+// rather than what was actually observed.
+func ResultsEquivalent(a, b Result, epsilon float64) bool {
+	if epsilon <= 0 {
+		epsilon = resultEpsilon
+	}
+	if (a.Error == nil) != (b.Error == nil) {
+		return false
+	}
+	if a.Error != nil && b.Error != nil && a.Error.Error() != b.Error.Error() {
+		return false
+	}
+	if a.Available != b.Available {
+		return false
+	}
+	if a.StatusCode != b.StatusCode {
+		return false
+	}
+	if !withinEpsilon(a.Vitals.KbpsOut, b.Vitals.KbpsOut, epsilon) {
+		return false
+	}
+	if !withinEpsilon(a.Vitals.LoadAvg, b.Vitals.LoadAvg, epsilon) {
+		return false
+	}
+	return true
+}
+
+func withinEpsilon(a, b, epsilon float64) bool {
+	return math.Abs(a-b) <= epsilon
+}
@@ -0,0 +1,51 @@
+package config
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+import "time"
+
+// Config holds the traffic_monitor process's own runtime configuration, as
+// opposed to tc.TrafficMonitorConfigMap, which holds the CDN configuration
+// fetched from Traffic Ops.
+type Config struct {
+	// HealthFlushMaxBatch caps how many queued health results
+	// healthResultManagerListen processes in a single flush.
+	HealthFlushMaxBatch int
+
+	// HealthFlushMaxLatency is the longest healthResultManagerListen will
+	// hold a batch's first result before flushing, regardless of how many
+	// more results keep arriving.
+	HealthFlushMaxLatency time.Duration
+
+	// HealthFlushMaxLinger is the longest healthResultManagerListen will wait
+	// after a batch's most recent result before flushing, letting a burst of
+	// closely-spaced results coalesce into one flush.
+	HealthFlushMaxLinger time.Duration
+
+	// HealthResultSpanEpsilon is how close two vitals values (e.g. KbpsOut,
+	// LoadAvg) must be to be considered equivalent when deciding whether to
+	// extend a run-length history span instead of starting a new one.
+	HealthResultSpanEpsilon float64
+
+	// HealthWorkerThreads is the number of health result worker goroutines
+	// StartHealthResultManager shards cache processing across. If unset (0),
+	// it defaults to runtime.GOMAXPROCS(0).
+	HealthWorkerThreads int
+}
@@ -0,0 +1,89 @@
+package health
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+import (
+	"fmt"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/apache/trafficcontrol/traffic_monitor/cache"
+	"github.com/apache/trafficcontrol/traffic_monitor/peer"
+	"github.com/apache/trafficcontrol/traffic_monitor/threadsafe"
+	"github.com/apache/trafficcontrol/traffic_monitor/todata"
+)
+
+// CalcAvailability determines each result's availability and records the
+// outcome on localCacheStatus and events; localStates is not written by this
+// function. A result's own Error
+// is checked first; if it has no error, every evaluator in evaluators is
+// ANDed in. The first evaluator (or the base error check) to fail a cache has
+// its reason recorded on the cache's health.Event, so operators can see why a
+// cache was marked unavailable on the /publish/EventLog endpoint.
+//
+// prevResults holds each result's own previous poll, index-aligned with
+// results - not the previous element of results itself, which since
+// StartHealthResultManager started sharding by cache name is usually a
+// different, unrelated cache. Callers that don't track a previous poll per
+// cache can pass a nil or short prevResults; missing entries are treated as
+// the zero Result.
+//
+// peers is consulted only by evaluators that need other monitors' opinions,
+// e.g. PeerQuorumEvaluator; callers with no peer state to offer can pass nil.
+func CalcAvailability(
+	results []cache.Result,
+	prevResults []cache.Result,
+	pollType string,
+	evaluators []AvailabilityEvaluator,
+	mc tc.TrafficMonitorConfigMap,
+	toData todata.TOData,
+	localCacheStatus threadsafe.CacheAvailableStatus,
+	localStates peer.CRStatesThreadsafe,
+	events ThreadsafeEvents,
+	peers PeerAgreement,
+) {
+	for i := range results {
+		result := results[i]
+		var prev cache.Result
+		if i < len(prevResults) {
+			prev = prevResults[i]
+		}
+
+		available, reason := evaluateAvailability(result, prev, evaluators, mc, toData, peers)
+
+		localCacheStatus.Set(result.ID, available, reason)
+		events.Add(Event{
+			Name:        result.ID,
+			Available:   available,
+			Description: fmt.Sprintf("%s poll: %s", pollType, reason),
+		})
+	}
+}
+
+// evaluateAvailability runs the base error check, then every evaluator in
+// chain in order, stopping at (and returning) the first failure.
+func evaluateAvailability(result cache.Result, prev cache.Result, chain []AvailabilityEvaluator, mc tc.TrafficMonitorConfigMap, toData todata.TOData, peers PeerAgreement) (bool, string) {
+	if result.Error != nil {
+		return false, result.Error.Error()
+	}
+	if ok, reason := EvaluateChain(chain, result, prev, mc, toData, peers); !ok {
+		return false, reason
+	}
+	return true, "available"
+}
@@ -0,0 +1,303 @@
+package health
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/trafficcontrol/lib/go-log"
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/apache/trafficcontrol/traffic_monitor/cache"
+	"github.com/apache/trafficcontrol/traffic_monitor/todata"
+)
+
+// PeerAgreement reports how many of a cache's peer Traffic Monitors
+// currently consider it available, out of how many have reported an opinion
+// on it at all. processHealthResult builds one of these from combinedStates,
+// the only place in the health pipeline with visibility into other monitors'
+// states.
+type PeerAgreement func(id tc.CacheName) (agreeing int, total int)
+
+// AvailabilityEvaluator is a single, named check that decides whether a cache
+// should be considered available, given its latest result and the previous
+// one. The final availability CalcAvailability reports is the AND of every
+// evaluator passed to it, and the first evaluator to fail has its reason
+// recorded on the resulting health.Event.
+type AvailabilityEvaluator interface {
+	// Name uniquely identifies the evaluator, both in the registry and in
+	// Traffic Ops profile parameters that select which evaluators to run.
+	Name() string
+	// Evaluate returns whether result is available, and if not, why. peers
+	// may be nil if the caller has no peer state to offer; evaluators that
+	// don't need it should just ignore it.
+	Evaluate(result cache.Result, prev cache.Result, mc tc.TrafficMonitorConfigMap, toData todata.TOData, peers PeerAgreement) (available bool, reason string)
+}
+
+var evaluatorRegistry = map[string]AvailabilityEvaluator{}
+
+// RegisterAvailabilityEvaluator adds an evaluator to the registry evaluator
+// chains are built from. It's expected to be called from init() by evaluator
+// implementations, the same way stat handlers register themselves elsewhere
+// in Traffic Monitor.
+func RegisterAvailabilityEvaluator(e AvailabilityEvaluator) {
+	evaluatorRegistry[e.Name()] = e
+}
+
+// EvaluateChain runs every evaluator in chain against result in order,
+// short-circuiting on the first failure so its reason can be attributed.
+// available is true only if every evaluator passes.
+func EvaluateChain(chain []AvailabilityEvaluator, result cache.Result, prev cache.Result, mc tc.TrafficMonitorConfigMap, toData todata.TOData, peers PeerAgreement) (available bool, reason string) {
+	for _, evaluator := range chain {
+		ok, why := evaluator.Evaluate(result, prev, mc, toData, peers)
+		if !ok {
+			return false, fmt.Sprintf("%s: %s", evaluator.Name(), why)
+		}
+	}
+	return true, ""
+}
+
+// EvaluatorChain builds an evaluator chain from names, a comma-separated list
+// of evaluator Names as configured in a Traffic Ops profile parameter (the
+// same parameter-driven pattern HistoryCount is read by, see
+// manager.processHealthResult). Unknown names are skipped with a log message
+// rather than treated as a fatal config error, so a typo in one evaluator's
+// name doesn't take down every other check.
+//
+// Most names are looked up directly in the registry, but an
+// "astats-assertion:<path>:<min>:<max>" name is parsed into a one-off
+// AstatsAssertionEvaluator instead, since its threshold is per-assertion
+// rather than per-profile and so can't be captured by a single static
+// registry entry the way MaxKbpsEvaluator's can.
+func EvaluatorChain(names string) []AvailabilityEvaluator {
+	var chain []AvailabilityEvaluator
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if evaluator, ok := parseAstatsAssertion(name); ok {
+			chain = append(chain, evaluator)
+			continue
+		}
+		evaluator, ok := evaluatorRegistry[name]
+		if !ok {
+			log.Warnf("EvaluatorChain: unknown availability evaluator %q, skipping\n", name)
+			continue
+		}
+		chain = append(chain, evaluator)
+	}
+	return chain
+}
+
+// parseAstatsAssertion parses an "astats-assertion:<stat-path>:<min>:<max>"
+// evaluator name, e.g.
+// "astats-assertion:proxy.process.http.cache_hit:0:1000000" fails a cache
+// whose cache_hit counter falls outside [0, 1000000].
+func parseAstatsAssertion(name string) (AstatsAssertionEvaluator, bool) {
+	if !strings.HasPrefix(name, "astats-assertion:") {
+		return AstatsAssertionEvaluator{}, false
+	}
+	parts := strings.Split(strings.TrimPrefix(name, "astats-assertion:"), ":")
+	if len(parts) != 3 {
+		return AstatsAssertionEvaluator{}, false
+	}
+	min, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return AstatsAssertionEvaluator{}, false
+	}
+	max, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return AstatsAssertionEvaluator{}, false
+	}
+	return AstatsAssertionEvaluator{StatPath: parts[0], Min: min, Max: max}, true
+}
+
+func init() {
+	RegisterAvailabilityEvaluator(MaxKbpsEvaluator{})
+	RegisterAvailabilityEvaluator(OriginErrorRateEvaluator{})
+	RegisterAvailabilityEvaluator(CertExpiryEvaluator{})
+	RegisterAvailabilityEvaluator(PeerQuorumEvaluator{})
+}
+
+// profileParameters returns the Traffic Ops profile parameters for a cache,
+// or the zero value if the cache or its profile isn't in mc.
+func profileParameters(id tc.CacheName, mc tc.TrafficMonitorConfigMap) tc.Parameters {
+	return mc.Profile[mc.TrafficServer[string(id)].Profile].Parameters
+}
+
+// MaxKbpsEvaluator fails a cache whose outbound bandwidth exceeds a
+// configured threshold. MaxKbps, if nonzero, takes precedence; otherwise the
+// threshold falls back to the cache's Traffic Ops profile parameter of the
+// same name, read from the profile the same way processHealthResult reads
+// HistoryCount.
+type MaxKbpsEvaluator struct {
+	MaxKbps int
+}
+
+// Name implements AvailabilityEvaluator.
+func (e MaxKbpsEvaluator) Name() string { return "max-kbps" }
+
+// Evaluate implements AvailabilityEvaluator.
+func (e MaxKbpsEvaluator) Evaluate(result cache.Result, prev cache.Result, mc tc.TrafficMonitorConfigMap, toData todata.TOData, peers PeerAgreement) (bool, string) {
+	maxKbps := e.MaxKbps
+	if maxKbps == 0 {
+		maxKbps = profileParameters(result.ID, mc).MaxKbps
+	}
+	if maxKbps == 0 {
+		return true, "no max-kbps threshold configured"
+	}
+	if result.Vitals.KbpsOut > float64(maxKbps) {
+		return false, fmt.Sprintf("kbps %f > max %d", result.Vitals.KbpsOut, maxKbps)
+	}
+	return true, "available"
+}
+
+// OriginErrorRateEvaluator fails a cache whose rate of origin errors, read
+// from the astats "origin.errors" counter, exceeds MaxErrorsPerSec.
+// MaxErrorsPerSec, if nonzero, takes precedence; otherwise the threshold
+// falls back to the cache's MaxErrorsPerSec profile parameter, the same
+// struct-field-else-profile-parameter pattern MaxKbpsEvaluator uses.
+type OriginErrorRateEvaluator struct {
+	MaxErrorsPerSec float64
+}
+
+// Name implements AvailabilityEvaluator.
+func (e OriginErrorRateEvaluator) Name() string { return "origin-error-rate" }
+
+// Evaluate implements AvailabilityEvaluator.
+func (e OriginErrorRateEvaluator) Evaluate(result cache.Result, prev cache.Result, mc tc.TrafficMonitorConfigMap, toData todata.TOData, peers PeerAgreement) (bool, string) {
+	maxErrorsPerSec := e.MaxErrorsPerSec
+	if maxErrorsPerSec == 0 {
+		maxErrorsPerSec = profileParameters(result.ID, mc).MaxErrorsPerSec
+	}
+	if maxErrorsPerSec <= 0 {
+		return true, "no max-errors-per-sec threshold configured"
+	}
+	seconds := result.Time.Sub(prev.Time).Seconds()
+	if seconds <= 0 {
+		return true, "no previous poll to diff against"
+	}
+	errorRate := (result.Astats.ATSStats["origin.errors"] - prev.Astats.ATSStats["origin.errors"]) / seconds
+	if errorRate > maxErrorsPerSec {
+		return false, fmt.Sprintf("origin error rate %f/s > max %f/s", errorRate, maxErrorsPerSec)
+	}
+	return true, "available"
+}
+
+// CertExpiryEvaluator fails a cache whose TLS certificate, as reported in
+// Result.CertExpiry, expires within MinRemaining of the poll time.
+// MinRemaining, if nonzero, takes precedence; otherwise the threshold falls
+// back to the cache's MinCertRemainingSeconds profile parameter.
+type CertExpiryEvaluator struct {
+	MinRemaining time.Duration
+}
+
+// Name implements AvailabilityEvaluator.
+func (e CertExpiryEvaluator) Name() string { return "cert-expiry" }
+
+// Evaluate implements AvailabilityEvaluator.
+func (e CertExpiryEvaluator) Evaluate(result cache.Result, prev cache.Result, mc tc.TrafficMonitorConfigMap, toData todata.TOData, peers PeerAgreement) (bool, string) {
+	minRemaining := e.MinRemaining
+	if minRemaining == 0 {
+		minRemaining = time.Duration(profileParameters(result.ID, mc).MinCertRemainingSeconds) * time.Second
+	}
+	if minRemaining <= 0 {
+		return true, "no min-remaining threshold configured"
+	}
+	if result.CertExpiry.IsZero() {
+		return true, "no cert expiry reported"
+	}
+	remaining := result.CertExpiry.Sub(result.Time)
+	if remaining < minRemaining {
+		return false, fmt.Sprintf("cert expires in %s < min %s", remaining, minRemaining)
+	}
+	return true, "available"
+}
+
+// PeerQuorumEvaluator fails a cache when fewer than MinPeers other Traffic
+// Monitors agree it's available, guarding against a single monitor's network
+// partition flapping a cache CDN-wide. MinPeers, if nonzero, takes
+// precedence; otherwise the threshold falls back to the cache's MinPeers
+// profile parameter. Agreement counts come from the peers callback, which
+// processHealthResult builds from combinedStates; when peers is nil, this
+// evaluator passes rather than guessing.
+type PeerQuorumEvaluator struct {
+	MinPeers int
+}
+
+// Name implements AvailabilityEvaluator.
+func (e PeerQuorumEvaluator) Name() string { return "peer-quorum" }
+
+// Evaluate implements AvailabilityEvaluator.
+func (e PeerQuorumEvaluator) Evaluate(result cache.Result, prev cache.Result, mc tc.TrafficMonitorConfigMap, toData todata.TOData, peers PeerAgreement) (bool, string) {
+	minPeers := e.MinPeers
+	if minPeers == 0 {
+		minPeers = profileParameters(result.ID, mc).MinPeers
+	}
+	if minPeers == 0 {
+		return true, "no min-peers threshold configured"
+	}
+	if peers == nil {
+		return true, "no peer state available"
+	}
+	agreeing, total := peers(result.ID)
+	if total == 0 {
+		return true, "no peer opinions reported"
+	}
+	if agreeing < minPeers {
+		return false, fmt.Sprintf("only %d/%d peers agree cache is available, need %d", agreeing, total, minPeers)
+	}
+	return true, "available"
+}
+
+// AstatsAssertionEvaluator fails a cache when a named astats counter falls
+// outside [Min, Max]. StatPath is the flattened stat name, e.g.
+// "origin.errors" or "proxy.process.http.cache_hit", matching the keys
+// AstatsData.ATSStats is populated under - the same flattened addressing
+// OriginErrorRateEvaluator uses, rather than re-parsing the raw astats JSON
+// per evaluator. Unlike the other evaluators, it's normally constructed via
+// an "astats-assertion:<path>:<min>:<max>" EvaluatorChain name rather than
+// registered once under a fixed Name(), since its threshold is per-assertion.
+type AstatsAssertionEvaluator struct {
+	StatPath string
+	Min      float64
+	Max      float64
+}
+
+// Name implements AvailabilityEvaluator.
+func (e AstatsAssertionEvaluator) Name() string { return "astats-assertion:" + e.StatPath }
+
+// Evaluate implements AvailabilityEvaluator.
+func (e AstatsAssertionEvaluator) Evaluate(result cache.Result, prev cache.Result, mc tc.TrafficMonitorConfigMap, toData todata.TOData, peers PeerAgreement) (bool, string) {
+	if e.StatPath == "" {
+		return true, "no stat path configured"
+	}
+	v, ok := result.Astats.ATSStats[e.StatPath]
+	if !ok {
+		return true, fmt.Sprintf("stat %q not present", e.StatPath)
+	}
+	if v < e.Min || v > e.Max {
+		return false, fmt.Sprintf("%s = %f outside [%f, %f]", e.StatPath, v, e.Min, e.Max)
+	}
+	return true, "available"
+}
@@ -0,0 +1,315 @@
+package health
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/apache/trafficcontrol/traffic_monitor/cache"
+	"github.com/apache/trafficcontrol/traffic_monitor/todata"
+)
+
+func TestMaxKbpsEvaluator(t *testing.T) {
+	cases := []struct {
+		name      string
+		evaluator MaxKbpsEvaluator
+		mc        tc.TrafficMonitorConfigMap
+		kbpsOut   float64
+		wantOK    bool
+	}{
+		{"no threshold configured passes", MaxKbpsEvaluator{}, tc.TrafficMonitorConfigMap{}, 1e9, true},
+		{"under struct-field threshold passes", MaxKbpsEvaluator{MaxKbps: 1000}, tc.TrafficMonitorConfigMap{}, 500, true},
+		{"over struct-field threshold fails", MaxKbpsEvaluator{MaxKbps: 1000}, tc.TrafficMonitorConfigMap{}, 1500, false},
+		{
+			"falls back to profile parameter when struct field unset",
+			MaxKbpsEvaluator{},
+			tc.TrafficMonitorConfigMap{
+				TrafficServer: map[string]tc.TrafficServer{"cache1": {Profile: "edge"}},
+				Profile:       map[string]tc.Profile{"edge": {Parameters: tc.Parameters{MaxKbps: 100}}},
+			},
+			200,
+			false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result := cache.Result{ID: "cache1", Vitals: cache.Vitals{KbpsOut: c.kbpsOut}}
+			ok, reason := c.evaluator.Evaluate(result, cache.Result{}, c.mc, todata.TOData{}, nil)
+			if ok != c.wantOK {
+				t.Fatalf("Evaluate() = (%v, %q), want ok=%v", ok, reason, c.wantOK)
+			}
+		})
+	}
+}
+
+func TestOriginErrorRateEvaluator(t *testing.T) {
+	now := time.Now()
+	prev := cache.Result{
+		ID:     "cache1",
+		Time:   now,
+		Astats: cache.AstatsData{ATSStats: map[string]float64{"origin.errors": 100}},
+	}
+
+	t.Run("no threshold configured passes", func(t *testing.T) {
+		result := cache.Result{ID: "cache1", Time: now.Add(time.Second), Astats: cache.AstatsData{ATSStats: map[string]float64{"origin.errors": 1000}}}
+		ok, _ := OriginErrorRateEvaluator{}.Evaluate(result, prev, tc.TrafficMonitorConfigMap{}, todata.TOData{}, nil)
+		if !ok {
+			t.Fatal("expected pass with no threshold configured")
+		}
+	})
+
+	t.Run("rate under threshold passes", func(t *testing.T) {
+		evaluator := OriginErrorRateEvaluator{MaxErrorsPerSec: 10}
+		result := cache.Result{ID: "cache1", Time: now.Add(time.Second), Astats: cache.AstatsData{ATSStats: map[string]float64{"origin.errors": 105}}}
+		ok, _ := evaluator.Evaluate(result, prev, tc.TrafficMonitorConfigMap{}, todata.TOData{}, nil)
+		if !ok {
+			t.Fatal("expected pass: (105-100)/1s = 5/s < 10/s max")
+		}
+	})
+
+	t.Run("rate over threshold fails", func(t *testing.T) {
+		evaluator := OriginErrorRateEvaluator{MaxErrorsPerSec: 10}
+		result := cache.Result{ID: "cache1", Time: now.Add(time.Second), Astats: cache.AstatsData{ATSStats: map[string]float64{"origin.errors": 200}}}
+		ok, reason := evaluator.Evaluate(result, prev, tc.TrafficMonitorConfigMap{}, todata.TOData{}, nil)
+		if ok {
+			t.Fatal("expected fail: (200-100)/1s = 100/s > 10/s max")
+		}
+		if reason == "" {
+			t.Fatal("expected a non-empty reason")
+		}
+	})
+
+	t.Run("no previous poll passes", func(t *testing.T) {
+		evaluator := OriginErrorRateEvaluator{MaxErrorsPerSec: 10}
+		result := cache.Result{ID: "cache1", Time: now, Astats: cache.AstatsData{ATSStats: map[string]float64{"origin.errors": 1000}}}
+		ok, _ := evaluator.Evaluate(result, cache.Result{}, tc.TrafficMonitorConfigMap{}, todata.TOData{}, nil)
+		if !ok {
+			t.Fatal("expected pass when prev.Time is zero (no interval to diff over)")
+		}
+	})
+
+	t.Run("falls back to profile parameter when struct field unset", func(t *testing.T) {
+		mc := tc.TrafficMonitorConfigMap{
+			TrafficServer: map[string]tc.TrafficServer{"cache1": {Profile: "edge"}},
+			Profile:       map[string]tc.Profile{"edge": {Parameters: tc.Parameters{MaxErrorsPerSec: 1}}},
+		}
+		result := cache.Result{ID: "cache1", Time: now.Add(time.Second), Astats: cache.AstatsData{ATSStats: map[string]float64{"origin.errors": 200}}}
+		ok, _ := OriginErrorRateEvaluator{}.Evaluate(result, prev, mc, todata.TOData{}, nil)
+		if ok {
+			t.Fatal("expected fail: 100/s > profile's 1/s max")
+		}
+	})
+}
+
+func TestCertExpiryEvaluator(t *testing.T) {
+	now := time.Now()
+
+	t.Run("no threshold configured passes", func(t *testing.T) {
+		result := cache.Result{ID: "cache1", Time: now, CertExpiry: now.Add(time.Hour)}
+		ok, _ := CertExpiryEvaluator{}.Evaluate(result, cache.Result{}, tc.TrafficMonitorConfigMap{}, todata.TOData{}, nil)
+		if !ok {
+			t.Fatal("expected pass with no threshold configured")
+		}
+	})
+
+	t.Run("no cert expiry reported passes", func(t *testing.T) {
+		evaluator := CertExpiryEvaluator{MinRemaining: 24 * time.Hour}
+		result := cache.Result{ID: "cache1", Time: now}
+		ok, _ := evaluator.Evaluate(result, cache.Result{}, tc.TrafficMonitorConfigMap{}, todata.TOData{}, nil)
+		if !ok {
+			t.Fatal("expected pass when CertExpiry is the zero value")
+		}
+	})
+
+	t.Run("expiry within min remaining fails", func(t *testing.T) {
+		evaluator := CertExpiryEvaluator{MinRemaining: 24 * time.Hour}
+		result := cache.Result{ID: "cache1", Time: now, CertExpiry: now.Add(time.Hour)}
+		ok, _ := evaluator.Evaluate(result, cache.Result{}, tc.TrafficMonitorConfigMap{}, todata.TOData{}, nil)
+		if ok {
+			t.Fatal("expected fail: 1h remaining < 24h min")
+		}
+	})
+
+	t.Run("expiry beyond min remaining passes", func(t *testing.T) {
+		evaluator := CertExpiryEvaluator{MinRemaining: 24 * time.Hour}
+		result := cache.Result{ID: "cache1", Time: now, CertExpiry: now.Add(48 * time.Hour)}
+		ok, _ := evaluator.Evaluate(result, cache.Result{}, tc.TrafficMonitorConfigMap{}, todata.TOData{}, nil)
+		if !ok {
+			t.Fatal("expected pass: 48h remaining >= 24h min")
+		}
+	})
+}
+
+func TestPeerQuorumEvaluator(t *testing.T) {
+	t.Run("no threshold configured passes", func(t *testing.T) {
+		ok, _ := PeerQuorumEvaluator{}.Evaluate(cache.Result{ID: "cache1"}, cache.Result{}, tc.TrafficMonitorConfigMap{}, todata.TOData{}, nil)
+		if !ok {
+			t.Fatal("expected pass with no threshold configured")
+		}
+	})
+
+	t.Run("nil peers passes", func(t *testing.T) {
+		evaluator := PeerQuorumEvaluator{MinPeers: 2}
+		ok, _ := evaluator.Evaluate(cache.Result{ID: "cache1"}, cache.Result{}, tc.TrafficMonitorConfigMap{}, todata.TOData{}, nil)
+		if !ok {
+			t.Fatal("expected pass when no peer state is offered")
+		}
+	})
+
+	t.Run("enough peers agree passes", func(t *testing.T) {
+		evaluator := PeerQuorumEvaluator{MinPeers: 2}
+		peers := func(id tc.CacheName) (int, int) { return 3, 4 }
+		ok, _ := evaluator.Evaluate(cache.Result{ID: "cache1"}, cache.Result{}, tc.TrafficMonitorConfigMap{}, todata.TOData{}, peers)
+		if !ok {
+			t.Fatal("expected pass: 3 agreeing >= 2 min")
+		}
+	})
+
+	t.Run("too few peers agree fails", func(t *testing.T) {
+		evaluator := PeerQuorumEvaluator{MinPeers: 2}
+		peers := func(id tc.CacheName) (int, int) { return 1, 4 }
+		ok, _ := evaluator.Evaluate(cache.Result{ID: "cache1"}, cache.Result{}, tc.TrafficMonitorConfigMap{}, todata.TOData{}, peers)
+		if ok {
+			t.Fatal("expected fail: 1 agreeing < 2 min")
+		}
+	})
+
+	t.Run("no peer opinions reported passes", func(t *testing.T) {
+		evaluator := PeerQuorumEvaluator{MinPeers: 2}
+		peers := func(id tc.CacheName) (int, int) { return 0, 0 }
+		ok, _ := evaluator.Evaluate(cache.Result{ID: "cache1"}, cache.Result{}, tc.TrafficMonitorConfigMap{}, todata.TOData{}, peers)
+		if !ok {
+			t.Fatal("expected pass when no peer has reported an opinion yet")
+		}
+	})
+}
+
+func TestAstatsAssertionEvaluator(t *testing.T) {
+	cases := []struct {
+		name      string
+		evaluator AstatsAssertionEvaluator
+		stats     map[string]float64
+		wantOK    bool
+	}{
+		{"no stat path configured passes", AstatsAssertionEvaluator{}, map[string]float64{"x": 5}, true},
+		{"stat not present passes", AstatsAssertionEvaluator{StatPath: "missing", Min: 0, Max: 10}, map[string]float64{"x": 5}, true},
+		{"within range passes", AstatsAssertionEvaluator{StatPath: "x", Min: 0, Max: 10}, map[string]float64{"x": 5}, true},
+		{"below range fails", AstatsAssertionEvaluator{StatPath: "x", Min: 0, Max: 10}, map[string]float64{"x": -1}, false},
+		{"above range fails", AstatsAssertionEvaluator{StatPath: "x", Min: 0, Max: 10}, map[string]float64{"x": 11}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result := cache.Result{ID: "cache1", Astats: cache.AstatsData{ATSStats: c.stats}}
+			ok, _ := c.evaluator.Evaluate(result, cache.Result{}, tc.TrafficMonitorConfigMap{}, todata.TOData{}, nil)
+			if ok != c.wantOK {
+				t.Fatalf("Evaluate() ok = %v, want %v", ok, c.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseAstatsAssertion(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		evaluator, ok := parseAstatsAssertion("astats-assertion:origin.errors:0:100")
+		if !ok {
+			t.Fatal("expected a parsed evaluator")
+		}
+		if evaluator.StatPath != "origin.errors" || evaluator.Min != 0 || evaluator.Max != 100 {
+			t.Fatalf("got %+v, want StatPath=origin.errors Min=0 Max=100", evaluator)
+		}
+	})
+
+	t.Run("not an assertion name", func(t *testing.T) {
+		if _, ok := parseAstatsAssertion("max-kbps"); ok {
+			t.Fatal("expected no match for a plain registry name")
+		}
+	})
+
+	t.Run("wrong field count", func(t *testing.T) {
+		if _, ok := parseAstatsAssertion("astats-assertion:origin.errors:0"); ok {
+			t.Fatal("expected no match with a missing field")
+		}
+	})
+
+	t.Run("non-numeric bound", func(t *testing.T) {
+		if _, ok := parseAstatsAssertion("astats-assertion:origin.errors:zero:100"); ok {
+			t.Fatal("expected no match with a non-numeric min")
+		}
+	})
+}
+
+func TestEvaluatorChain(t *testing.T) {
+	t.Run("known registry names resolve", func(t *testing.T) {
+		chain := EvaluatorChain("max-kbps, cert-expiry")
+		if len(chain) != 2 {
+			t.Fatalf("got %d evaluators, want 2", len(chain))
+		}
+		if chain[0].Name() != "max-kbps" || chain[1].Name() != "cert-expiry" {
+			t.Fatalf("got %v, want [max-kbps cert-expiry] in order", chain)
+		}
+	})
+
+	t.Run("unknown names are skipped", func(t *testing.T) {
+		chain := EvaluatorChain("max-kbps,not-a-real-evaluator,cert-expiry")
+		if len(chain) != 2 {
+			t.Fatalf("got %d evaluators, want 2 (unknown name skipped)", len(chain))
+		}
+	})
+
+	t.Run("astats-assertion names are parsed inline", func(t *testing.T) {
+		chain := EvaluatorChain("astats-assertion:origin.errors:0:100")
+		if len(chain) != 1 {
+			t.Fatalf("got %d evaluators, want 1", len(chain))
+		}
+		assertion, ok := chain[0].(AstatsAssertionEvaluator)
+		if !ok {
+			t.Fatalf("got %T, want AstatsAssertionEvaluator", chain[0])
+		}
+		if assertion.StatPath != "origin.errors" {
+			t.Fatalf("got StatPath %q, want origin.errors", assertion.StatPath)
+		}
+	})
+
+	t.Run("blank and empty names are ignored", func(t *testing.T) {
+		chain := EvaluatorChain(" , ,max-kbps, ")
+		if len(chain) != 1 {
+			t.Fatalf("got %d evaluators, want 1", len(chain))
+		}
+	})
+}
+
+func TestEvaluateChainShortCircuitsOnFirstFailure(t *testing.T) {
+	chain := EvaluatorChain("max-kbps,origin-error-rate")
+	result := cache.Result{ID: "cache1", Vitals: cache.Vitals{KbpsOut: 2000}}
+	mc := tc.TrafficMonitorConfigMap{
+		TrafficServer: map[string]tc.TrafficServer{"cache1": {Profile: "edge"}},
+		Profile:       map[string]tc.Profile{"edge": {Parameters: tc.Parameters{MaxKbps: 1000, MaxErrorsPerSec: 1}}},
+	}
+	ok, reason := EvaluateChain(chain, result, cache.Result{}, mc, todata.TOData{}, nil)
+	if ok {
+		t.Fatal("expected failure: kbps over max")
+	}
+	if !strings.HasPrefix(reason, "max-kbps:") {
+		t.Fatalf("got reason %q, want it attributed to max-kbps (the first evaluator in the chain)", reason)
+	}
+}
@@ -20,6 +20,8 @@ package manager
  */
 
 import (
+	"hash/fnv"
+	"runtime"
 	"time"
 
 	"github.com/apache/trafficcontrol/lib/go-log"
@@ -32,11 +34,46 @@ import (
 	"github.com/apache/trafficcontrol/traffic_monitor/todata"
 )
 
-// StartHealthResultManager starts the goroutine which listens for health results.
+// defaultHealthFlushMaxLatency and defaultHealthFlushMaxLinger are used when
+// cfg.HealthFlushMaxLatency/HealthFlushMaxLinger are unset (<=0), the same
+// way maxBatch falls back to 1. Without a floor here, a zero-valued duration
+// would fire its timer immediately, flushing almost every single result as
+// its own batch - silently reproducing the per-result thrash this batching
+// was added to fix.
+const (
+	defaultHealthFlushMaxLatency = time.Second
+	defaultHealthFlushMaxLinger  = 100 * time.Millisecond
+)
+
+// healthResultShardCount returns the number of health result worker shards to run, per cfg.HealthWorkerThreads. If unset, it defaults to GOMAXPROCS so the manager scales with the box it's running on.
+func healthResultShardCount(cfg config.Config) int {
+	if cfg.HealthWorkerThreads > 0 {
+		return cfg.HealthWorkerThreads
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// healthResultShardFor deterministically maps a cache name to one of numShards shards, so the same cache is always processed by the same worker and its history is never observed out of order.
+func healthResultShardFor(name tc.CacheName, numShards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32() % uint32(numShards))
+}
+
+// StartHealthResultManager starts the goroutines which listen for health results.
 // Note this polls the brief stat endpoint from ATS Astats, not the full stats.
 // This poll should be quicker and less computationally expensive for ATS, but
 // doesn't include all stat data needed for e.g. delivery service calculations.4
-// Returns the last health durations, events, the local cache statuses, and the health result history.
+//
+// Processing is sharded by cache name across multiple worker goroutines, each
+// owning a disjoint set of caches, so that no single goroutine is a bottleneck
+// for the whole CDN. A dispatcher goroutine reads cacheHealthChan and routes
+// each result to the worker that owns its cache, keyed by a stable hash of the
+// cache name. Because a cache's results are always handled by the same worker,
+// each worker can safely keep its own lastHealthEndTimes map without locking.
+// Returns the last health durations, the health result history, and the
+// batching stats each shard's collectHealthResultBatches call reports on
+// flush.
 func StartHealthResultManager(
 	cacheHealthChan <-chan cache.Result,
 	toData todata.TODataThreadsafe,
@@ -48,24 +85,45 @@ func StartHealthResultManager(
 	cfg config.Config,
 	events health.ThreadsafeEvents,
 	localCacheStatus threadsafe.CacheAvailableStatus,
-) (threadsafe.DurationMap, threadsafe.ResultHistory) {
+) (threadsafe.DurationMap, threadsafe.ResultHistory, threadsafe.HealthBatchStatsThreadsafe) {
 	lastHealthDurations := threadsafe.NewDurationMap()
 	healthHistory := threadsafe.NewResultHistory()
-	go healthResultManagerListen(
-		cacheHealthChan,
-		toData,
-		localStates,
-		lastHealthDurations,
-		healthHistory,
-		monitorConfig,
-		combinedStates,
-		fetchCount,
-		errorCount,
-		events,
-		localCacheStatus,
-		cfg,
-	)
-	return lastHealthDurations, healthHistory
+	batchStats := threadsafe.NewHealthBatchStatsThreadsafe()
+
+	numShards := healthResultShardCount(cfg)
+	shardChans := make([]chan cache.Result, numShards)
+	for i := range shardChans {
+		shardChans[i] = make(chan cache.Result, cap(cacheHealthChan))
+		go healthResultManagerListen(
+			shardChans[i],
+			toData,
+			localStates,
+			lastHealthDurations,
+			healthHistory,
+			monitorConfig,
+			combinedStates,
+			fetchCount,
+			errorCount,
+			events,
+			localCacheStatus,
+			batchStats,
+			cfg,
+		)
+	}
+	go dispatchHealthResultsToShards(cacheHealthChan, shardChans)
+
+	return lastHealthDurations, healthHistory, batchStats
+}
+
+// dispatchHealthResultsToShards reads results from cacheHealthChan and forwards each to the shard channel owning its cache, so that every result for a given cache is always processed by the same worker. It closes every shard channel once cacheHealthChan closes, so the worker goroutines listening on them exit instead of leaking.
+func dispatchHealthResultsToShards(cacheHealthChan <-chan cache.Result, shardChans []chan cache.Result) {
+	numShards := len(shardChans)
+	for result := range cacheHealthChan {
+		shardChans[healthResultShardFor(result.ID, numShards)] <- result
+	}
+	for _, shardChan := range shardChans {
+		close(shardChan)
+	}
 }
 
 func healthResultManagerListen(
@@ -80,13 +138,14 @@ func healthResultManagerListen(
 	errorCount threadsafe.Uint,
 	events health.ThreadsafeEvents,
 	localCacheStatus threadsafe.CacheAvailableStatus,
+	batchStats threadsafe.HealthBatchStatsThreadsafe,
 	cfg config.Config,
 ) {
 	lastHealthEndTimes := map[tc.CacheName]time.Time{}
-	// This reads at least 1 value from the cacheHealthChan. Then, we loop, and try to read from the channel some more. If there's nothing to read, we hit `default` and process. If there is stuff to read, we read it, then inner-loop trying to read more. If we're continuously reading and the channel is never empty, and we hit the tick time, process anyway even though the channel isn't empty, to prevent never processing (starvation).
-	var ticker *time.Ticker
 
-	process := func(results []cache.Result) {
+	collectHealthResultBatches(cacheHealthChan, cfg, func(results []cache.Result, stats threadsafe.HealthBatchStats) {
+		batchStats.Set(stats)
+		log.Infof("Health Result Manager flushing %d queued results (queue depth %d, flush latency %s)\n", len(results), stats.QueueDepth, stats.FlushLatency)
 		processHealthResult(
 			cacheHealthChan,
 			toData,
@@ -103,36 +162,98 @@ func healthResultManagerListen(
 			results,
 			cfg,
 		)
+	})
+}
+
+// collectHealthResultBatches reads cacheHealthChan, coalescing results into
+// batches and calling process with each one, up to three limits:
+// HealthFlushMaxBatch results queued, HealthFlushMaxLatency elapsed since the
+// batch's first result, or HealthFlushMaxLinger elapsed since its most recent
+// result. The linger deadline is what lets a burst of closely-spaced results
+// (e.g. a thundering herd of cache polls returning together) coalesce into
+// one flush instead of one per result; the latency deadline bounds how stale
+// the oldest result in a batch can get while waiting on a slow trickle.
+//
+// Alongside each batch, process receives a threadsafe.HealthBatchStats
+// snapshot of that flush - its size, how many results were already queued
+// up behind it, and how long its first result waited - so callers can
+// surface batching behavior to operators without reaching back into this
+// loop's own timers. Computing it here, rather than in the caller, keeps it
+// accurate to what this function actually measured instead of an
+// approximation taken after the fact.
+//
+// It returns once cacheHealthChan is closed, flushing any partial batch
+// first. In production a worker's channel is closed only by
+// dispatchHealthResultsToShards, once cacheHealthChan itself closes. Tests
+// drive this function directly to assert batch boundaries deterministically
+// without needing the rest of the health result pipeline's dependencies.
+func collectHealthResultBatches(cacheHealthChan <-chan cache.Result, cfg config.Config, process func(results []cache.Result, stats threadsafe.HealthBatchStats)) {
+	maxBatch := cfg.HealthFlushMaxBatch
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+	maxLatency := cfg.HealthFlushMaxLatency
+	if maxLatency <= 0 {
+		maxLatency = defaultHealthFlushMaxLatency
+	}
+	maxLinger := cfg.HealthFlushMaxLinger
+	if maxLinger <= 0 {
+		maxLinger = defaultHealthFlushMaxLinger
 	}
 
 	for {
-		var results []cache.Result
-		results = append(results, <-cacheHealthChan)
-		if ticker != nil {
-			ticker.Stop()
+		first, ok := <-cacheHealthChan
+		if !ok {
+			return
 		}
-		ticker = time.NewTicker(cfg.HealthFlushInterval)
-	innerLoop:
-		for {
+		batchStart := time.Now()
+		results := []cache.Result{first}
+
+		latencyTimer := time.NewTimer(maxLatency)
+		lingerTimer := time.NewTimer(maxLinger)
+
+	collecting:
+		for len(results) < maxBatch {
 			select {
-			case <-ticker.C:
-				log.Infof("Health Result Manager flushing queued results\n")
-				process(results)
-				break innerLoop
-			default:
-				select {
-				case r := <-cacheHealthChan:
-					results = append(results, r)
-				default:
-					process(results)
-					break innerLoop
+			case r, ok := <-cacheHealthChan:
+				if !ok {
+					break collecting
+				}
+				results = append(results, r)
+				if !lingerTimer.Stop() {
+					<-lingerTimer.C
 				}
+				lingerTimer.Reset(maxLinger)
+			case <-latencyTimer.C:
+				break collecting
+			case <-lingerTimer.C:
+				break collecting
 			}
 		}
+		stopTimer(latencyTimer)
+		stopTimer(lingerTimer)
+
+		process(results, threadsafe.HealthBatchStats{
+			BatchSize:    len(results),
+			QueueDepth:   len(cacheHealthChan),
+			FlushLatency: time.Since(batchStart),
+		})
 	}
 }
 
-// processHealthResult processes the given health results, adding their stats to the CacheAvailableStatus. Note this is NOT threadsafe, because it non-atomically gets CacheAvailableStatuses, Events, LastHealthDurations and later updates them. This MUST NOT be called from multiple threads.
+// stopTimer stops t, draining its channel if it had already fired, so a
+// timer can be safely discarded (or reused) without a stale fire leaking
+// into whatever reads its channel next.
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
+
+// processHealthResult processes the given health results, adding their stats to the CacheAvailableStatus. Note this is NOT threadsafe, because it non-atomically gets CacheAvailableStatuses, Events, LastHealthDurations and later updates them. This MUST NOT be called from multiple threads for the same shard. Since shards own disjoint sets of cache names, results passed to a single call are always a subset of one shard's caches.
 func processHealthResult(
 	cacheHealthChan <-chan cache.Result,
 	toData todata.TODataThreadsafe,
@@ -161,14 +282,33 @@ func processHealthResult(
 
 	toDataCopy := toData.Get() // create a copy, so the same data used for all processing of this cache health result
 	monitorConfigCopy := monitorConfig.Get()
-	healthHistoryCopy := healthHistory.Get().Copy()
+
+	// Since each shard owns a disjoint set of cache names (see
+	// StartHealthResultManager), this only ever reads/writes history for the
+	// caches in this batch, each via its own shard lock in healthHistory -
+	// never a whole-map copy-and-replace, which would silently clobber
+	// concurrent updates from shards that own other caches.
+	touchedHistory := map[tc.CacheName][]cache.Result{}
+	historyFor := func(id tc.CacheName) []cache.Result {
+		if h, ok := touchedHistory[id]; ok {
+			return h
+		}
+		h := healthHistory.Result(id)
+		touchedHistory[id] = h
+		return h
+	}
+
+	prevResults := make([]cache.Result, len(results))
 	for i, healthResult := range results {
 		fetchCount.Inc()
 		var prevResult cache.Result
-		healthResultHistory := healthHistoryCopy[healthResult.ID]
+		healthResultHistory := historyFor(healthResult.ID)
 		if len(healthResultHistory) != 0 {
-			prevResult = healthResultHistory[len(healthResultHistory)-1]
+			// healthResultHistory is newest-first (see pruneHistory), so the
+			// previous poll is the head of the slice, not the tail.
+			prevResult = healthResultHistory[0]
 		}
+		prevResults[i] = prevResult
 
 		if healthResult.Error == nil {
 			health.GetVitals(&healthResult, &prevResult, &monitorConfigCopy)
@@ -181,21 +321,84 @@ func processHealthResult(
 			maxHistory = 1
 		}
 
-		healthHistoryCopy[healthResult.ID] = pruneHistory(append([]cache.Result{healthResult}, healthHistoryCopy[healthResult.ID]...), maxHistory)
+		// Most consecutive polls of a given cache report the same availability, error
+		// and vitals, so rather than pushing a new history entry every poll we extend
+		// the span of the most recent entry when the new result is equivalent to it.
+		// This keeps ResultHistory's distinct-entry count - and therefore pruneHistory's
+		// work - proportional to the number of *changes* a cache has gone through rather
+		// than the number of times it's been polled.
+		if len(healthResultHistory) != 0 && cache.ResultsEquivalent(healthResult, healthResultHistory[0], cfg.HealthResultSpanEpsilon) {
+			// healthResultHistory is newest-first (see pruneHistory), so the
+			// entry to extend is the head of the slice, not the tail.
+			merged := healthResultHistory[0]
+			merged.Span++
+			merged.LastTime = healthResult.Time
+			touchedHistory[healthResult.ID] = append([]cache.Result{merged}, healthResultHistory[1:]...)
+			continue
+		}
+
+		healthResult.Span = 1
+		healthResult.FirstTime = healthResult.Time
+		healthResult.LastTime = healthResult.Time
+		touchedHistory[healthResult.ID] = pruneHistory(append([]cache.Result{healthResult}, healthResultHistory...), maxHistory)
 	}
 
-	health.CalcAvailability(results, "health", nil, monitorConfigCopy, toDataCopy, localCacheStatusThreadsafe, localStates, events)
+	// CalcAvailability's decision for a cache depends only on that cache's own
+	// current and previous results plus the immutable monitorConfigCopy/
+	// toDataCopy snapshot, never on another cache's state, so it's safe to
+	// call here with just this shard's batch of results. prevResults is
+	// index-aligned with results and gives CalcAvailability each cache's own
+	// previous poll - NOT results[i-1], which since processing is sharded by
+	// cache name is usually an unrelated cache. Any cachegroup-level (or
+	// other cross-cache) aggregation must happen downstream of every shard,
+	// e.g. in combineCrStates below, not in this per-shard call.
+	//
+	// Which evaluators apply is itself a per-cache, Traffic-Ops-configured
+	// setting (AvailabilityEvaluators, read the same way HistoryCount is
+	// above), so caches in this batch are grouped by their evaluator list
+	// before calling CalcAvailability, rather than assuming the whole batch
+	// shares one chain.
+	// peerAgreement gives evaluators like PeerQuorumEvaluator visibility into
+	// other Traffic Monitors' opinions of a cache, via combinedStates - the
+	// only thing in this pipeline that tracks peer state.
+	peerAgreement := func(id tc.CacheName) (agreeing int, total int) {
+		return combinedStates.PeerAvailability(id)
+	}
 
-	healthHistory.Set(healthHistoryCopy)
+	evaluatorGroups := map[string][]int{}
+	for i, result := range results {
+		names := monitorConfigCopy.Profile[monitorConfigCopy.TrafficServer[string(result.ID)].Profile].Parameters.AvailabilityEvaluators
+		evaluatorGroups[names] = append(evaluatorGroups[names], i)
+	}
+	for names, indices := range evaluatorGroups {
+		groupResults := make([]cache.Result, len(indices))
+		groupPrevResults := make([]cache.Result, len(indices))
+		for j, i := range indices {
+			groupResults[j] = results[i]
+			groupPrevResults[j] = prevResults[i]
+		}
+		health.CalcAvailability(groupResults, groupPrevResults, "health", health.EvaluatorChain(names), monitorConfigCopy, toDataCopy, localCacheStatusThreadsafe, localStates, events, peerAgreement)
+	}
+
+	for id, history := range touchedHistory {
+		healthHistory.SetResult(id, history)
+	}
 	// TODO determine if we should combineCrStates() here
 
-	lastHealthDurations := threadsafe.CopyDurationMap(lastHealthDurationsThreadsafe.Get())
 	for _, healthResult := range results {
 		if lastHealthStart, ok := lastHealthEndTimes[healthResult.ID]; ok {
-			d := time.Since(lastHealthStart)
-			lastHealthDurations[healthResult.ID] = d
+			lastHealthDurationsThreadsafe.SetDuration(healthResult.ID, time.Since(lastHealthStart))
 		}
 		lastHealthEndTimes[healthResult.ID] = time.Now()
 	}
-	lastHealthDurationsThreadsafe.Set(lastHealthDurations)
+}
+
+// pruneHistory trims history - newest entry first, as built by
+// processHealthResult - down to at most limit distinct (post-span-compression)
+// entries, dropping the oldest ones.
+func pruneHistory(history []cache.Result, limit uint64) []cache.Result {
+	if limit > 0 && uint64(len(history)) > limit {
+		history = history[:limit]
+	}
+	return history
 }
@@ -0,0 +1,205 @@
+package manager
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/apache/trafficcontrol/traffic_monitor/cache"
+	"github.com/apache/trafficcontrol/traffic_monitor/config"
+	"github.com/apache/trafficcontrol/traffic_monitor/threadsafe"
+)
+
+// runBatches drives collectHealthResultBatches against cacheHealthChan in the
+// background and returns a channel of the batches it flushes, in order.
+// Closing cacheHealthChan stops the collector once any in-flight batch is
+// flushed.
+func runBatches(cacheHealthChan <-chan cache.Result, cfg config.Config) <-chan []cache.Result {
+	batches := make(chan []cache.Result, 16)
+	go func() {
+		defer close(batches)
+		collectHealthResultBatches(cacheHealthChan, cfg, func(results []cache.Result, stats threadsafe.HealthBatchStats) {
+			batches <- append([]cache.Result{}, results...)
+		})
+	}()
+	return batches
+}
+
+// awaitBatch waits up to 1s for the next batch, failing the test on timeout
+// so a bug that drops or never flushes a batch fails fast instead of hanging.
+func awaitBatch(t *testing.T, batches <-chan []cache.Result) []cache.Result {
+	t.Helper()
+	select {
+	case b, ok := <-batches:
+		if !ok {
+			t.Fatal("collectHealthResultBatches stopped early, expected another batch")
+		}
+		return b
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a batch")
+		return nil
+	}
+}
+
+func resultNamed(name string) cache.Result {
+	return cache.Result{ID: tc.CacheName(name), Time: time.Now()}
+}
+
+func TestCollectHealthResultBatchesFlushesOnMaxBatch(t *testing.T) {
+	cfg := config.Config{
+		HealthFlushMaxBatch:   3,
+		HealthFlushMaxLatency: time.Second,
+		HealthFlushMaxLinger:  time.Second,
+	}
+	cacheHealthChan := make(chan cache.Result)
+	batches := runBatches(cacheHealthChan, cfg)
+
+	cacheHealthChan <- resultNamed("a")
+	cacheHealthChan <- resultNamed("b")
+	cacheHealthChan <- resultNamed("c")
+
+	batch := awaitBatch(t, batches)
+	if len(batch) != 3 {
+		t.Fatalf("got batch of %d results, want 3 (maxBatch boundary)", len(batch))
+	}
+	if batch[0].ID != "a" || batch[1].ID != "b" || batch[2].ID != "c" {
+		t.Fatalf("got batch %v, want [a b c] in arrival order", batch)
+	}
+
+	close(cacheHealthChan)
+}
+
+func TestCollectHealthResultBatchesFlushesOnLinger(t *testing.T) {
+	cfg := config.Config{
+		HealthFlushMaxBatch:   10,
+		HealthFlushMaxLatency: time.Second,
+		HealthFlushMaxLinger:  20 * time.Millisecond,
+	}
+	cacheHealthChan := make(chan cache.Result)
+	batches := runBatches(cacheHealthChan, cfg)
+
+	cacheHealthChan <- resultNamed("a")
+	cacheHealthChan <- resultNamed("b")
+	// Nothing else arrives - the linger deadline, not maxBatch, should end
+	// this batch well before HealthFlushMaxLatency would.
+	batch := awaitBatch(t, batches)
+	if len(batch) != 2 {
+		t.Fatalf("got batch of %d results, want 2 (linger boundary)", len(batch))
+	}
+
+	// A result arriving after the linger-triggered flush starts a new batch.
+	cacheHealthChan <- resultNamed("c")
+	batch = awaitBatch(t, batches)
+	if len(batch) != 1 || batch[0].ID != "c" {
+		t.Fatalf("got batch %v, want a fresh batch containing only c", batch)
+	}
+
+	close(cacheHealthChan)
+}
+
+func TestCollectHealthResultBatchesFlushesOnLatencyDespiteTrickle(t *testing.T) {
+	cfg := config.Config{
+		HealthFlushMaxBatch:   10,
+		HealthFlushMaxLatency: 30 * time.Millisecond,
+		HealthFlushMaxLinger:  time.Second,
+	}
+	cacheHealthChan := make(chan cache.Result)
+	batches := runBatches(cacheHealthChan, cfg)
+
+	// Trickle results in slowly enough that each one resets the linger
+	// deadline, so only the latency deadline - bounding staleness of the
+	// batch's oldest result - can end this batch.
+	stop := time.After(200 * time.Millisecond)
+	sent := 0
+trickle:
+	for {
+		select {
+		case <-time.After(10 * time.Millisecond):
+			cacheHealthChan <- resultNamed("t")
+			sent++
+		case <-stop:
+			break trickle
+		}
+	}
+
+	batch := awaitBatch(t, batches)
+	if len(batch) == 0 || len(batch) >= sent {
+		t.Fatalf("got batch of %d results out of %d sent, want a latency-triggered flush partway through the trickle", len(batch), sent)
+	}
+
+	close(cacheHealthChan)
+}
+
+func TestCollectHealthResultBatchesReportsStats(t *testing.T) {
+	cfg := config.Config{
+		HealthFlushMaxBatch:   2,
+		HealthFlushMaxLatency: time.Second,
+		HealthFlushMaxLinger:  time.Second,
+	}
+	cacheHealthChan := make(chan cache.Result, 4)
+	statsChan := make(chan threadsafe.HealthBatchStats, 16)
+	go func() {
+		collectHealthResultBatches(cacheHealthChan, cfg, func(results []cache.Result, stats threadsafe.HealthBatchStats) {
+			statsChan <- stats
+		})
+	}()
+
+	// Queue a third result behind the two that will fill the first batch, so
+	// QueueDepth should reflect the one result still waiting after flush.
+	cacheHealthChan <- resultNamed("a")
+	cacheHealthChan <- resultNamed("b")
+	cacheHealthChan <- resultNamed("c")
+
+	select {
+	case stats := <-statsChan:
+		if stats.BatchSize != 2 {
+			t.Fatalf("got BatchSize %d, want 2 (maxBatch boundary)", stats.BatchSize)
+		}
+		if stats.QueueDepth != 1 {
+			t.Fatalf("got QueueDepth %d, want 1 (c still queued behind the a/b batch)", stats.QueueDepth)
+		}
+		if stats.FlushLatency <= 0 {
+			t.Fatal("got a non-positive FlushLatency, want the elapsed time since the batch's first result")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for batch stats")
+	}
+
+	close(cacheHealthChan)
+}
+
+func TestCollectHealthResultBatchesReturnsOnClose(t *testing.T) {
+	cfg := config.Config{HealthFlushMaxBatch: 1}
+	cacheHealthChan := make(chan cache.Result)
+	batches := runBatches(cacheHealthChan, cfg)
+
+	close(cacheHealthChan)
+
+	select {
+	case _, ok := <-batches:
+		if ok {
+			t.Fatal("got an unexpected batch after closing an empty channel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("collectHealthResultBatches did not return after cacheHealthChan closed")
+	}
+}
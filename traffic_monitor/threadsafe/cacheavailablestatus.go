@@ -0,0 +1,101 @@
+package threadsafe
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+import (
+	"sync"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// CacheStatus is the last known availability of a single cache.
+type CacheStatus struct {
+	Available bool
+	Status    string
+}
+
+type cacheAvailableStatusShard struct {
+	mu sync.RWMutex
+	m  map[tc.CacheName]CacheStatus
+}
+
+// CacheAvailableStatus is a threadsafe map of the last known availability of
+// every cache, keyed by cache name. It shares the shard-per-key pattern used
+// by ResultHistory and DurationMap so that health result workers can write
+// their own cache's status without contending with other shards.
+type CacheAvailableStatus struct {
+	shards []*cacheAvailableStatusShard
+}
+
+// NewCacheAvailableStatus returns a new CacheAvailableStatus using
+// DefaultShardCount shards.
+func NewCacheAvailableStatus() CacheAvailableStatus {
+	return NewCacheAvailableStatusShards(DefaultShardCount)
+}
+
+// NewCacheAvailableStatusShards returns a new CacheAvailableStatus sharded
+// across numShards independently-locked buckets.
+func NewCacheAvailableStatusShards(numShards int) CacheAvailableStatus {
+	if numShards < 1 {
+		numShards = 1
+	}
+	shards := make([]*cacheAvailableStatusShard, numShards)
+	for i := range shards {
+		shards[i] = &cacheAvailableStatusShard{m: map[tc.CacheName]CacheStatus{}}
+	}
+	return CacheAvailableStatus{shards: shards}
+}
+
+func (c CacheAvailableStatus) shardFor(id tc.CacheName) *cacheAvailableStatusShard {
+	return c.shards[shardIndex(id, len(c.shards))]
+}
+
+// Set records the given cache's availability and status reason. This only
+// takes the one shard lock that owns id, so it's safe to call concurrently
+// from every health result worker as long as each worker only calls it for
+// caches it owns.
+func (c CacheAvailableStatus) Set(id tc.CacheName, available bool, status string) {
+	shard := c.shardFor(id)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.m[id] = CacheStatus{Available: available, Status: status}
+}
+
+// Get returns the given cache's last recorded availability.
+func (c CacheAvailableStatus) Get(id tc.CacheName) (CacheStatus, bool) {
+	shard := c.shardFor(id)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	s, ok := shard.m[id]
+	return s, ok
+}
+
+// All returns a snapshot of every cache's last recorded availability.
+func (c CacheAvailableStatus) All() map[tc.CacheName]CacheStatus {
+	all := map[tc.CacheName]CacheStatus{}
+	for _, shard := range c.shards {
+		shard.mu.RLock()
+		for id, s := range shard.m {
+			all[id] = s
+		}
+		shard.mu.RUnlock()
+	}
+	return all
+}
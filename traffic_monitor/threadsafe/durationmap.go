@@ -0,0 +1,121 @@
+package threadsafe
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+import (
+	"sync"
+	"time"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// DurationMapData is a snapshot of every cache's last health poll duration,
+// keyed by cache name.
+type DurationMapData map[tc.CacheName]time.Duration
+
+// CopyDurationMap returns a copy of m, safe to mutate without affecting the
+// original.
+func CopyDurationMap(m DurationMapData) DurationMapData {
+	newMap := make(DurationMapData, len(m))
+	for k, v := range m {
+		newMap[k] = v
+	}
+	return newMap
+}
+
+type durationMapShard struct {
+	mu sync.RWMutex
+	m  map[tc.CacheName]time.Duration
+}
+
+// DurationMap is a threadsafe store of every cache's last health poll
+// duration, sharded the same way as ResultHistory so that health result
+// workers can record their own cache's duration via SetDuration without
+// contending with other workers' shards.
+type DurationMap struct {
+	shards []*durationMapShard
+}
+
+// NewDurationMap returns a new, empty DurationMap using DefaultShardCount
+// shards.
+func NewDurationMap() DurationMap {
+	return NewDurationMapShards(DefaultShardCount)
+}
+
+// NewDurationMapShards returns a new, empty DurationMap with the given
+// number of shards.
+func NewDurationMapShards(numShards int) DurationMap {
+	if numShards < 1 {
+		numShards = 1
+	}
+	shards := make([]*durationMapShard, numShards)
+	for i := range shards {
+		shards[i] = &durationMapShard{m: map[tc.CacheName]time.Duration{}}
+	}
+	return DurationMap{shards: shards}
+}
+
+func (d DurationMap) shardFor(id tc.CacheName) *durationMapShard {
+	return d.shards[shardIndex(id, len(d.shards))]
+}
+
+// Duration returns a single cache's last recorded poll duration.
+func (d DurationMap) Duration(id tc.CacheName) (time.Duration, bool) {
+	shard := d.shardFor(id)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	dur, ok := shard.m[id]
+	return dur, ok
+}
+
+// SetDuration records a single cache's poll duration. This only takes the
+// one shard lock that owns id, so it's safe to call concurrently from every
+// health result worker as long as each worker only calls it for caches it
+// owns.
+func (d DurationMap) SetDuration(id tc.CacheName, dur time.Duration) {
+	shard := d.shardFor(id)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.m[id] = dur
+}
+
+// Get returns a full snapshot of every cache's last poll duration.
+func (d DurationMap) Get() DurationMapData {
+	all := make(DurationMapData)
+	for _, shard := range d.shards {
+		shard.mu.RLock()
+		for id, dur := range shard.m {
+			all[id] = dur
+		}
+		shard.mu.RUnlock()
+	}
+	return all
+}
+
+// Set bulk-loads every duration in v, distributing each key to the shard
+// that owns it. As with ResultHistory.Set, per-poll updates from a health
+// result worker MUST use SetDuration instead - calling Set concurrently from
+// multiple workers reintroduces the lost-update race SetDuration exists to
+// avoid.
+func (d DurationMap) Set(v DurationMapData) {
+	for id, dur := range v {
+		d.SetDuration(id, dur)
+	}
+}
@@ -0,0 +1,75 @@
+package threadsafe
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+import (
+	"sync"
+	"time"
+)
+
+// HealthBatchStats is a snapshot of the most recent health result batch
+// collectHealthResultBatches flushed, surfaced so operators can tune
+// HealthFlushMaxBatch/MaxLatency/MaxLinger from observed behavior instead of
+// guessing, e.g. on the /publish/Stats endpoint.
+type HealthBatchStats struct {
+	// BatchSize is the number of results the flush contained.
+	BatchSize int
+	// QueueDepth is the number of results already buffered on the health
+	// result channel at flush time, i.e. the backlog the next batch starts
+	// with.
+	QueueDepth int
+	// FlushLatency is how long the batch's first result waited before this
+	// flush, bounded above by HealthFlushMaxLatency.
+	FlushLatency time.Duration
+}
+
+// HealthBatchStatsThreadsafe is a threadsafe holder for the single latest
+// HealthBatchStats. Unlike ResultHistory/DurationMap/CacheAvailableStatus,
+// this isn't keyed or sharded by cache name - a health result batch spans
+// many caches at once, so there's only ever one snapshot to hold.
+type HealthBatchStatsThreadsafe struct {
+	data *healthBatchStatsData
+}
+
+type healthBatchStatsData struct {
+	mu    sync.RWMutex
+	stats HealthBatchStats
+}
+
+// NewHealthBatchStatsThreadsafe returns a new HealthBatchStatsThreadsafe
+// holding the zero HealthBatchStats, as if no batch had flushed yet.
+func NewHealthBatchStatsThreadsafe() HealthBatchStatsThreadsafe {
+	return HealthBatchStatsThreadsafe{data: &healthBatchStatsData{}}
+}
+
+// Set records the latest flushed batch's stats, overwriting whatever was
+// there before.
+func (t HealthBatchStatsThreadsafe) Set(stats HealthBatchStats) {
+	t.data.mu.Lock()
+	defer t.data.mu.Unlock()
+	t.data.stats = stats
+}
+
+// Get returns the most recently flushed batch's stats.
+func (t HealthBatchStatsThreadsafe) Get() HealthBatchStats {
+	t.data.mu.RLock()
+	defer t.data.mu.RUnlock()
+	return t.data.stats
+}
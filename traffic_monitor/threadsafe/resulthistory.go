@@ -0,0 +1,171 @@
+package threadsafe
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+import (
+	"sync"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/apache/trafficcontrol/traffic_monitor/cache"
+)
+
+// ResultHistoryMap is a snapshot of every cache's health result history, keyed
+// by cache name. Each slice is stored run-length compressed: consecutive
+// equivalent polls are merged into a single entry with Span > 1 rather than
+// one entry per poll.
+type ResultHistoryMap map[tc.CacheName][]cache.Result
+
+// Copy returns a deep copy of m, safe to mutate without affecting the
+// original.
+func (m ResultHistoryMap) Copy() ResultHistoryMap {
+	newMap := make(ResultHistoryMap, len(m))
+	for k, v := range m {
+		newHistory := make([]cache.Result, len(v))
+		copy(newHistory, v)
+		newMap[k] = newHistory
+	}
+	return newMap
+}
+
+type resultHistoryShard struct {
+	mu sync.RWMutex
+	m  map[tc.CacheName][]cache.Result
+}
+
+// ResultHistory is a threadsafe store of every cache's health result
+// history. It's backed by a fixed number of independently-locked shards
+// keyed by a stable hash of the cache name, so that health result workers
+// which each own a disjoint set of caches (see manager.StartHealthResultManager)
+// don't serialize on one global lock: Result/SetResult only ever take the one
+// shard lock that owns the given cache name, and workers contend only when
+// their caches hash to the same shard.
+type ResultHistory struct {
+	shards []*resultHistoryShard
+}
+
+// NewResultHistory returns a new, empty ResultHistory using DefaultShardCount
+// shards.
+func NewResultHistory() ResultHistory {
+	return NewResultHistoryShards(DefaultShardCount)
+}
+
+// NewResultHistoryShards returns a new, empty ResultHistory with the given
+// number of shards. Callers that shard their own processing - e.g. health
+// result workers, one per CPU - should generally just use DefaultShardCount,
+// which is independent of (and normally larger than) the worker count; what
+// matters for correctness is that each *cache* always hashes to the same
+// shard, not that shard count matches worker count.
+func NewResultHistoryShards(numShards int) ResultHistory {
+	if numShards < 1 {
+		numShards = 1
+	}
+	shards := make([]*resultHistoryShard, numShards)
+	for i := range shards {
+		shards[i] = &resultHistoryShard{m: map[tc.CacheName][]cache.Result{}}
+	}
+	return ResultHistory{shards: shards}
+}
+
+func (r ResultHistory) shardFor(id tc.CacheName) *resultHistoryShard {
+	return r.shards[shardIndex(id, len(r.shards))]
+}
+
+// Result returns a single cache's compressed history. This only takes the
+// lock of the one shard that owns id, so concurrent workers processing other
+// caches are never blocked by it.
+func (r ResultHistory) Result(id tc.CacheName) []cache.Result {
+	shard := r.shardFor(id)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	history := shard.m[id]
+	entries := make([]cache.Result, len(history))
+	copy(entries, history)
+	return entries
+}
+
+// SetResult replaces a single cache's compressed history. Like Result, this
+// only takes the one shard lock that owns id, so it's safe to call
+// concurrently from every health result worker as long as each worker only
+// ever calls it for caches it owns - which StartHealthResultManager's
+// hash-based dispatch guarantees.
+func (r ResultHistory) SetResult(id tc.CacheName, history []cache.Result) {
+	shard := r.shardFor(id)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.m[id] = history
+}
+
+// Get returns a full snapshot of every cache's history, for callers - like
+// the /publish/CacheStats endpoint - that need to enumerate every cache at
+// once. It takes each shard's lock in turn rather than one global lock, so it
+// can't observe a single instant in time across all caches, but since no
+// shard lock is ever held across more than one cache's update that's no
+// looser a guarantee than the old single-mutex map gave callers anyway.
+func (r ResultHistory) Get() ResultHistoryMap {
+	all := make(ResultHistoryMap)
+	for _, shard := range r.shards {
+		shard.mu.RLock()
+		for id, history := range shard.m {
+			entries := make([]cache.Result, len(history))
+			copy(entries, history)
+			all[id] = entries
+		}
+		shard.mu.RUnlock()
+	}
+	return all
+}
+
+// Set bulk-loads every cache history in v, distributing each key to the
+// shard that owns it. This is for whole-map operations like restoring
+// history at startup; per-poll updates from a health result worker MUST use
+// SetResult instead - calling Set from multiple concurrent workers
+// reintroduces the lost-update race SetResult exists to avoid, since it
+// iterates and sets every shard's keys rather than just the caller's own.
+func (r ResultHistory) Set(v ResultHistoryMap) {
+	for id, history := range v {
+		r.SetResult(id, history)
+	}
+}
+
+// Entries returns the compressed history for a single cache: one entry per
+// distinct run, with Span indicating how many consecutive polls it covers.
+// This is what pruneHistory counts against HistoryCount.
+func (r ResultHistory) Entries(id tc.CacheName) []cache.Result {
+	return r.Result(id)
+}
+
+// Expanded returns a single cache's history with every compressed entry
+// repeated Span times, so that consumers which expect one entry per poll -
+// e.g. the /publish/CacheStats endpoint - see a logically equivalent
+// timeline to the uncompressed one.
+func (r ResultHistory) Expanded(id tc.CacheName) []cache.Result {
+	history := r.Result(id)
+	expanded := make([]cache.Result, 0, len(history))
+	for _, entry := range history {
+		span := entry.Span
+		if span < 1 {
+			span = 1
+		}
+		for i := 0; i < span; i++ {
+			expanded = append(expanded, entry)
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,64 @@
+package threadsafe
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+import (
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/apache/trafficcontrol/traffic_monitor/cache"
+)
+
+// TestResultHistoryExpandedLegacyPeerEntry confirms the migration path
+// documented on cache.Result.Span: an entry from a monitor that predates span
+// compression, and so never set Span (leaving it at the zero value), expands
+// to exactly one poll rather than zero - the same as a freshly polled,
+// not-yet-merged Result would. This is what lets a mixed cluster of old and
+// new Traffic Monitors exchange history without either side special-casing
+// the other's version.
+func TestResultHistoryExpandedLegacyPeerEntry(t *testing.T) {
+	history := NewResultHistory()
+	legacy := cache.Result{ID: "cache1"} // Span, FirstTime, LastTime all zero-value, as an old peer would send
+	history.SetResult("cache1", []cache.Result{legacy})
+
+	expanded := history.Expanded("cache1")
+	if len(expanded) != 1 {
+		t.Fatalf("got %d expanded entries for a legacy Span==0 entry, want 1", len(expanded))
+	}
+	if expanded[0].ID != tc.CacheName("cache1") {
+		t.Fatalf("got expanded entry %+v, want it to match the legacy entry", expanded[0])
+	}
+}
+
+// TestResultHistoryExpandedMixedLegacyAndSpanned confirms a legacy (Span==0)
+// entry expands correctly alongside a normal compressed entry in the same
+// history, as would happen immediately after upgrading one monitor in a
+// cluster where peers haven't upgraded yet.
+func TestResultHistoryExpandedMixedLegacyAndSpanned(t *testing.T) {
+	history := NewResultHistory()
+	legacy := cache.Result{ID: "cache1"}
+	spanned := cache.Result{ID: "cache1", Span: 3}
+	history.SetResult("cache1", []cache.Result{spanned, legacy})
+
+	expanded := history.Expanded("cache1")
+	if len(expanded) != 4 {
+		t.Fatalf("got %d expanded entries, want 4 (3 from the spanned entry, 1 from the legacy entry)", len(expanded))
+	}
+}
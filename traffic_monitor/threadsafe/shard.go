@@ -0,0 +1,45 @@
+package threadsafe
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+import (
+	"hash/fnv"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// DefaultShardCount is the shard count NewResultHistory, NewDurationMap and
+// NewCacheAvailableStatus use; the New*Shards constructors take an explicit
+// count instead. StartHealthResultManager uses the defaults, so this count is
+// independent of the health result worker count, and two workers' caches can
+// hash to the same shard.
+const DefaultShardCount = 32
+
+// shardIndex deterministically maps a cache name to one of numShards shards.
+// The same cache name always maps to the same shard, so a sharded map reader
+// enumerating every shard sees a consistent, if not perfectly atomic, view.
+func shardIndex(id tc.CacheName, numShards int) int {
+	if numShards < 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(numShards))
+}